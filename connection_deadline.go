@@ -0,0 +1,53 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux && !race
+// +build linux,!race
+
+package netpoll
+
+import "time"
+
+// SetReadDeadline sets the deadline for future Reader calls on the
+// connection, mirroring net.Conn.SetReadDeadline. A zero Time disables the
+// deadline. Unlike WithReadTimeout (a fixed per-loop duration applied up
+// front), this can be changed per-call and per-connection.
+//
+// Only supported when the connection's poller is the epoll-backed
+// defaultPoll; on other Poll implementations (e.g. uringPoll) it is a no-op,
+// same as calling it on a connection type that never wires up a timing wheel.
+func (c *connection) SetReadDeadline(t time.Time) error {
+	if poll, ok := c.operator.poll.(*defaultPoll); ok {
+		poll.setDeadline(c.operator, deadlineRead, t)
+	}
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for future Writer calls on the
+// connection, mirroring net.Conn.SetWriteDeadline.
+func (c *connection) SetWriteDeadline(t time.Time) error {
+	if poll, ok := c.operator.poll.(*defaultPoll); ok {
+		poll.setDeadline(c.operator, deadlineWrite, t)
+	}
+	return nil
+}
+
+// SetDeadline sets both the read and write deadlines, mirroring
+// net.Conn.SetDeadline.
+func (c *connection) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
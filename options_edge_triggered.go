@@ -0,0 +1,35 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux && !race
+// +build linux,!race
+
+package netpoll
+
+// WithEdgeTriggered switches the poller from level-triggered EPOLLIN (one
+// readv per wakeup) to edge-triggered EPOLLIN|EPOLLET, draining each fd with
+// a multi-segment readv loop until EAGAIN. This trades a slightly more
+// expensive read path for fewer epoll_wait wakeups on connections that
+// deliver data in several small chunks per RTT.
+//
+// Must be set before the first poller is opened (i.e. before NewEventLoop or
+// the first dial/listen); it applies process-wide, not per-connection. A
+// call arriving after that point panics instead of silently changing a
+// setting a running poller already committed to (see checkOptionsUnlocked).
+func WithEdgeTriggered(et bool) Option {
+	return Option{func(op *options) {
+		checkOptionsUnlocked("WithEdgeTriggered")
+		edgeTriggered = et
+	}}
+}
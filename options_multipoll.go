@@ -0,0 +1,50 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+// numPollers and lockOSThreadPollers are consulted by openPoll(), same as
+// the other process-wide poll options (uringEnabled, edgeTriggered): the
+// Poll backend is picked once per process at openPoll() time, so there is
+// nowhere to thread a per-call options value through to it. openPoll() calls
+// lockOptions() before returning, and the With* setters below call
+// checkOptionsUnlocked() first, so a call arriving after the first poller
+// opened panics instead of silently changing these out from under it.
+var (
+	numPollers          = 1
+	lockOSThreadPollers bool
+)
+
+// WithNumPollers sets the number of poller shards the event loop's listener
+// spreads accepted connections across (see multiPoll). Defaults to 1, which
+// keeps the original single-epoll-fd behavior. Must be set before the first
+// poller is opened; it applies process-wide, not per-connection.
+func WithNumPollers(n int) Option {
+	return Option{func(op *options) {
+		checkOptionsUnlocked("WithNumPollers")
+		numPollers = n
+	}}
+}
+
+// WithLockOSThread pins each poller shard to its own OS thread via
+// runtime.LockOSThread, trading a few extra OS threads for avoiding
+// goroutine-scheduler jitter on the hot epoll_wait loop. Only meaningful
+// together with WithNumPollers(n) for n > 1. Must be set before the first
+// poller is opened; it applies process-wide, not per-connection.
+func WithLockOSThread(lock bool) Option {
+	return Option{func(op *options) {
+		checkOptionsUnlocked("WithLockOSThread")
+		lockOSThreadPollers = lock
+	}}
+}
@@ -0,0 +1,34 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+// uringEnabled gates the io_uring backend behind an explicit opt-in: even on
+// a kernel new enough to pass kernelSupportsIOUring(), openPoll() keeps using
+// the epoll-backed defaultPoll unless WithIOUring(true) was called, so
+// picking up this backend is a deliberate choice rather than a surprise
+// swap underneath every existing deployment.
+var uringEnabled bool
+
+// WithIOUring opts into the io_uring-backed Poll implementation on kernels
+// that support it (falling back to epoll otherwise). Must be set before the
+// first poller is opened; like the other global poll options it applies
+// process-wide, and a late call panics rather than silently stomping a
+// running poller's setting (see checkOptionsUnlocked).
+func WithIOUring(enabled bool) Option {
+	return Option{func(op *options) {
+		checkOptionsUnlocked("WithIOUring")
+		uringEnabled = enabled
+	}}
+}
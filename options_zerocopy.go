@@ -0,0 +1,36 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+// zeroCopyWrite gates the MSG_ZEROCOPY send path behind an explicit opt-in:
+// it changes buffer-release timing (deferred until the kernel confirms
+// completion via MSG_ERRQUEUE) so it must not turn on implicitly underneath
+// callers that assume OutputAck happens synchronously with sendmsg.
+var zeroCopyWrite bool
+
+// WithZeroCopyWrite enables the MSG_ZEROCOPY send path for writes at or
+// above the copy-avoidance threshold (~10KB); smaller writes always use a
+// regular copying sendmsg, where MSG_ZEROCOPY's page-pinning and errqueue
+// bookkeeping would be a net loss.
+//
+// Must be set before the first poller is opened; like WithEdgeTriggered it
+// applies process-wide, and a late call panics rather than silently
+// stomping a running poller's setting (see checkOptionsUnlocked).
+func WithZeroCopyWrite(zc bool) Option {
+	return Option{func(op *options) {
+		checkOptionsUnlocked("WithZeroCopyWrite")
+		zeroCopyWrite = zc
+	}}
+}
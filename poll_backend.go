@@ -0,0 +1,55 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import "sync/atomic"
+
+// The Poll backend is selected per-GOOS at compile time rather than through
+// a runtime lookup table: every platform provides its own openPoll() behind
+// a build-tagged file (poll_default_linux.go for epoll, poll_default_bsd.go
+// for kqueue on darwin/freebsd, poll_default_solaris.go for event ports),
+// the same way the Go runtime picks netpoll_epoll.go/netpoll_kqueue.go/
+// netpoll_solaris.go per GOOS. On darwin/freebsd and solaris openPoll()
+// always returns that platform's defaultPoll. On linux openPoll() still
+// chooses at runtime among uringPoll (WithIOUring on a capable kernel),
+// multiPoll (WithNumPollers > 1) and the epoll defaultPoll.
+
+// optionsLocked flips to 1 the first time any platform's openPoll() runs.
+// WithNumPollers/WithLockOSThread/WithEdgeTriggered/WithZeroCopyWrite/
+// WithIOUring each store into a package-level var read by openPoll(), and
+// every one of their doc comments already claims "must be set before the
+// first poller is opened" — this makes that claim an enforced constraint
+// rather than an assertion nobody checks: a call arriving after a poller
+// already exists would otherwise silently change process-wide behavior out
+// from under connections the running poller already committed to.
+var optionsLocked uint32
+
+// lockOptions is called once by each platform's openPoll() before it
+// returns. Safe to call from more than one openPoll() invocation (e.g. a
+// process that opens several event loops with the same settings); only the
+// first call has any effect.
+func lockOptions() {
+	atomic.StoreUint32(&optionsLocked, 1)
+}
+
+// checkOptionsUnlocked panics if called after the first poller has already
+// been opened. Each process-wide With* option setter calls this before
+// touching its package-level var, turning a late call into a loud failure
+// instead of a silent stomp.
+func checkOptionsUnlocked(name string) {
+	if atomic.LoadUint32(&optionsLocked) != 0 {
+		panic("netpoll: " + name + " called after the first poller was already opened; this option is process-wide and must be set before NewEventLoop or the first dial/listen")
+	}
+}
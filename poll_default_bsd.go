@@ -0,0 +1,278 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build (darwin || freebsd) && !race
+// +build darwin freebsd
+// +build !race
+
+package netpoll
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// triggerIdent is the ident kqueuePoll registers its EVFILT_USER wakeup
+// filter under; it is the largest positive uintptr, far above any fd number,
+// so it never collides with a real fd.
+const triggerIdent = ^uintptr(0) >> 1
+
+// Includes defaultPoll (kqueue)... mirrors the epoll backend's openPoll, just
+// selected automatically by this file's darwin/freebsd build constraint
+// instead of an explicit registry, the same way Go's own runtime netpoller
+// picks netpoll_kqueue.go vs netpoll_epoll.go per GOOS.
+func openPoll() Poll {
+	lockOptions()
+	return openDefaultPoll()
+}
+
+func openDefaultPoll() *defaultPoll {
+	var poll = defaultPoll{}
+	kq, err := KqueueCreate()
+	if err != nil {
+		panic(err)
+	}
+	poll.fd = kq
+
+	poll.Reset = poll.reset
+	poll.Handler = poll.handler
+
+	poll.wop = &FDOperator{FD: int(triggerIdent)}
+	changes := []syscall.Kevent_t{{
+		Ident:  uint64(triggerIdent),
+		Filter: syscall.EVFILT_USER,
+		Flags:  syscall.EV_ADD | syscall.EV_CLEAR,
+	}}
+	if err := KeventCtl(kq, changes); err != nil {
+		syscall.Close(kq)
+		panic(err)
+	}
+	poll.opcache = newOperatorCache()
+	return &poll
+}
+
+type defaultPoll struct {
+	fd       int            // kqueue fd
+	wop      *FDOperator    // EVFILT_USER ident, wake kevent
+	trigger  uint32         // trigger flag
+	opcache  *operatorCache // operator cache
+	size     int
+	events   []syscall.Kevent_t
+	barriers []barrier
+	hups     []func(p Poll) error
+	// writeArmed tracks which operators currently have an EVFILT_WRITE knote
+	// registered. Most connections only ever go through PollReadable and
+	// never register EVFILT_WRITE at all, so PollDetach must not delete it
+	// unconditionally: EV_DELETE against a filter the kernel never armed
+	// comes back ENOENT, which would otherwise log a spurious detach failure
+	// on every ordinary read-only connection's close.
+	writeArmed sync.Map // map[*FDOperator]struct{}
+	// fns for handle events
+	Reset   func(size int)
+	Handler func(events []syscall.Kevent_t) (closed bool)
+}
+
+func (p *defaultPoll) reset(size int) {
+	p.size = size
+	p.events = make([]syscall.Kevent_t, size)
+	p.barriers = make([]barrier, size)
+	for i := range p.barriers {
+		p.barriers[i].bs = make([][]byte, barriercap)
+		p.barriers[i].ivs = make([]syscall.Iovec, barriercap)
+	}
+}
+
+// Wait implements Poll.
+func (p *defaultPoll) Wait() (err error) {
+	var msec, n = -1, 0
+	p.Reset(128)
+	for {
+		if n == p.size && p.size < 128*1024 {
+			p.Reset(p.size << 1)
+		}
+		n, err = KeventWait(p.fd, p.events, msec)
+		if err != nil && err != syscall.EINTR {
+			return err
+		}
+		if n <= 0 {
+			msec = -1
+			runtime.Gosched()
+			continue
+		}
+		msec = 0
+		if p.Handler(p.events[:n]) {
+			return nil
+		}
+		p.opcache.free()
+	}
+}
+
+func (p *defaultPoll) handler(events []syscall.Kevent_t) (closed bool) {
+	for i := range events {
+		ev := &events[i]
+		if uintptr(ev.Ident) == triggerIdent {
+			atomic.StoreUint32(&p.trigger, 0)
+			continue
+		}
+
+		operator := operatorFromUdata((*byte)(ev.Udata))
+		if operator == nil || !operator.do() {
+			continue
+		}
+
+		// kqueue reports fflags/data per-event rather than a combined
+		// bitmask, so read and write are always separate Kevent_t entries
+		// even for the same fd (unlike epoll's single EPOLLIN|EPOLLOUT evt).
+		switch ev.Filter {
+		case syscall.EVFILT_READ:
+			if operator.OnRead != nil {
+				operator.OnRead(p)
+			} else if operator.Inputs != nil {
+				var bs = operator.Inputs(p.barriers[i].bs)
+				if len(bs) > 0 {
+					// ev.Data is the kernel's estimate of bytes currently
+					// readable; readv still sizes itself off bs/ivs, but we
+					// could use it to pre-size the next Inputs() call more
+					// precisely than the epoll backend can.
+					var n, err = readv(operator.FD, bs, p.barriers[i].ivs)
+					operator.InputAck(n)
+					if err != nil && err != syscall.EAGAIN && err != syscall.EINTR {
+						logger.Printf("NETPOLL: readv(fd=%d) failed: %s", operator.FD, err.Error())
+						p.appendHup(operator)
+						continue
+					}
+				}
+			}
+		case syscall.EVFILT_WRITE:
+			if operator.OnWrite != nil {
+				operator.OnWrite(p)
+			} else if operator.Outputs != nil {
+				var bs, supportZeroCopy = operator.Outputs(p.barriers[i].bs)
+				if len(bs) > 0 {
+					var n, err = sendmsg(operator.FD, bs, p.barriers[i].ivs, false && supportZeroCopy)
+					operator.OutputAck(n)
+					if err != nil && err != syscall.EAGAIN {
+						logger.Printf("NETPOLL: sendmsg(fd=%d) failed: %s", operator.FD, err.Error())
+						p.appendHup(operator)
+						continue
+					}
+				}
+			}
+		}
+
+		if ev.Flags&syscall.EV_EOF != 0 {
+			p.appendHup(operator)
+			continue
+		}
+		operator.done()
+	}
+	p.detaches()
+	return false
+}
+
+// Close implements Poll.
+func (p *defaultPoll) Close() error {
+	return syscall.Close(p.fd)
+}
+
+// Trigger implements Poll: firing the EVFILT_USER filter wakes a blocked
+// kevent() the same way writing the eventfd wakes epoll_wait.
+func (p *defaultPoll) Trigger() error {
+	if atomic.AddUint32(&p.trigger, 1) > 1 {
+		return nil
+	}
+	changes := []syscall.Kevent_t{{
+		Ident:  uint64(triggerIdent),
+		Filter: syscall.EVFILT_USER,
+		Fflags: syscall.NOTE_TRIGGER,
+	}}
+	return KeventCtl(p.fd, changes)
+}
+
+// Control implements Poll, mapping the six PollEvent cases below onto
+// EV_ADD/EV_ENABLE/EV_DISABLE/EV_DELETE for EVFILT_READ/EVFILT_WRITE.
+func (p *defaultPoll) Control(operator *FDOperator, event PollEvent) error {
+	udata := operatorToUdata(operator)
+	mk := func(filter int16, flags uint16) syscall.Kevent_t {
+		return syscall.Kevent_t{
+			Ident:  uint64(operator.FD),
+			Filter: filter,
+			Flags:  flags,
+			Udata:  udata,
+		}
+	}
+	switch event {
+	case PollReadable: // server accept a new connection and wait read
+		operator.inuse()
+		return KeventCtl(p.fd, []syscall.Kevent_t{mk(syscall.EVFILT_READ, syscall.EV_ADD|syscall.EV_ENABLE)})
+	case PollWritable: // client create a new connection and wait connect finished
+		operator.inuse()
+		p.writeArmed.Store(operator, struct{}{})
+		return KeventCtl(p.fd, []syscall.Kevent_t{mk(syscall.EVFILT_WRITE, syscall.EV_ADD|syscall.EV_ENABLE)})
+	case PollModReadable: // client wait read/write
+		// EV_ADD alongside EV_ENABLE: EV_ENABLE alone assumes the EVFILT_READ
+		// knote is still registered, but if it was previously torn down (e.g.
+		// a prior PollDetach) there is nothing left to enable and kevent
+		// returns ENOENT. EV_ADD is a no-op when the knote already exists, so
+		// it's safe to include unconditionally here.
+		return KeventCtl(p.fd, []syscall.Kevent_t{mk(syscall.EVFILT_READ, syscall.EV_ADD|syscall.EV_ENABLE)})
+	case PollDetach: // deregister
+		changes := []syscall.Kevent_t{mk(syscall.EVFILT_READ, syscall.EV_DELETE)}
+		if _, ok := p.writeArmed.LoadAndDelete(operator); ok {
+			changes = append(changes, mk(syscall.EVFILT_WRITE, syscall.EV_DELETE))
+		}
+		return KeventCtl(p.fd, changes)
+	case PollR2RW: // connection wait read/write
+		p.writeArmed.Store(operator, struct{}{})
+		return KeventCtl(p.fd, []syscall.Kevent_t{mk(syscall.EVFILT_WRITE, syscall.EV_ADD|syscall.EV_ENABLE)})
+	case PollRW2R: // connection wait read
+		return KeventCtl(p.fd, []syscall.Kevent_t{mk(syscall.EVFILT_WRITE, syscall.EV_DISABLE)})
+	}
+	return nil
+}
+
+func (p *defaultPoll) Alloc() (operator *FDOperator) {
+	op := p.opcache.alloc()
+	op.poll = p
+	return op
+}
+
+func (p *defaultPoll) Free(operator *FDOperator) {
+	p.opcache.freeable(operator)
+}
+
+func (p *defaultPoll) appendHup(operator *FDOperator) {
+	p.hups = append(p.hups, operator.OnHup)
+	if err := operator.Control(PollDetach); err != nil {
+		logger.Printf("NETPOLL: poller detach operator failed: %v", err)
+	}
+	operator.done()
+}
+
+func (p *defaultPoll) detaches() {
+	if len(p.hups) == 0 {
+		return
+	}
+	hups := p.hups
+	p.hups = nil
+	go func(onhups []func(p Poll) error) {
+		for i := range onhups {
+			if onhups[i] != nil {
+				onhups[i](p)
+			}
+		}
+	}(hups)
+}
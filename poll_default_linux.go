@@ -19,13 +19,24 @@ package netpoll
 
 import (
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
 // Includes defaultPoll/multiPoll/uringPoll...
 func openPoll() Poll {
+	lockOptions()
+	if uringEnabled && kernelSupportsIOUring() {
+		if poll, err := openUringPoll(); err == nil {
+			return poll
+		}
+	}
+	if numPollers > 1 {
+		return openMultiPoll(numPollers, lockOSThreadPollers)
+	}
 	return openDefaultPoll()
 }
 
@@ -47,6 +58,8 @@ func openDefaultPoll() *defaultPoll {
 	poll.Handler = poll.handler
 
 	poll.wop = &FDOperator{FD: int(r0)}
+	poll.et = edgeTriggered
+	poll.tw = newTimingWheel(nowMs())
 	poll.Control(poll.wop, PollReadable)
 	poll.opcache = newOperatorCache()
 	return &poll
@@ -54,16 +67,31 @@ func openDefaultPoll() *defaultPoll {
 
 type defaultPoll struct {
 	pollArgs
-	fd      int            // epoll fd
-	wop     *FDOperator    // eventfd, wake epoll_wait
-	buf     []byte         // read wfd trigger msg
-	trigger uint32         // trigger flag
-	opcache *operatorCache // operator cache
+	fd        int            // epoll fd
+	wop       *FDOperator    // eventfd, wake epoll_wait
+	buf       []byte         // read wfd trigger msg
+	trigger   uint32         // trigger flag
+	opcache   *operatorCache // operator cache
+	et        bool           // use edge-triggered EPOLLIN and drain-loop reads
+	tw        *timingWheel   // per-operator read/write deadlines
+	deadlines sync.Map       // map[deadlineKey]*twEntry, keyed by operator+kind
+	zcPending sync.Map       // map[*FDOperator]*zcQueue, in-flight MSG_ZEROCOPY writes
 	// fns for handle events
 	Reset   func(size, caps int)
 	Handler func(events []epollevent) (closed bool)
 }
 
+// edgeTriggered is flipped once, before any poll is opened, by
+// WithEdgeTriggered. All pollers created afterwards register reads as
+// EPOLLET and drain each fd with a multi-segment readv loop instead of a
+// single level-triggered read per wakeup.
+var edgeTriggered bool
+
+// barrierCapET is the number of iovecs a poller reserves per event when
+// edge-triggered mode is on, so a single readv can batch several LinkBuffer
+// free chunks instead of the caps=1 the level-triggered path uses.
+const barrierCapET = 8
+
 type pollArgs struct {
 	size     int
 	caps     int
@@ -86,6 +114,9 @@ func (a *pollArgs) reset(size, caps int) {
 func (p *defaultPoll) Wait() (err error) {
 	// init
 	var caps, msec, n = barriercap, -1, 0
+	if p.et {
+		caps = barrierCapET
+	}
 	p.Reset(128, caps)
 	// wait
 	for {
@@ -96,8 +127,25 @@ func (p *defaultPoll) Wait() (err error) {
 		if err != nil && err != syscall.EINTR {
 			return err
 		}
+		now := nowMs()
+		p.tw.advance(now)
 		if n <= 0 {
-			msec = -1
+			// advance() may have just queued OnDeadline callbacks for expired
+			// deadlines (see deadlineExpired); handler() is what normally
+			// flushes p.hups via detaches(), but handler() isn't called on
+			// this path, so do it directly here or a deadline on an
+			// otherwise-idle connection would never unpark its Reader/Writer
+			// until some unrelated fd event happened to fire.
+			p.detaches()
+			// Instead of always blocking indefinitely (-1) or busy-polling
+			// (0), sleep exactly until the nearest SetReadDeadline/
+			// SetWriteDeadline entry wants to fire; Trigger() is what wakes
+			// us early if a shorter deadline gets scheduled meanwhile.
+			if next := p.tw.nextExpirationMs(now); next >= 0 {
+				msec = int(next)
+			} else {
+				msec = -1
+			}
 			runtime.Gosched()
 			continue
 		}
@@ -112,6 +160,7 @@ func (p *defaultPoll) Wait() (err error) {
 
 // 核心函数，处理事件
 func (p *defaultPoll) handler(events []epollevent) (closed bool) {
+events:
 	for i := range events {
 		var operator = *(**FDOperator)(unsafe.Pointer(&events[i].data))
 
@@ -144,19 +193,63 @@ func (p *defaultPoll) handler(events []epollevent) (closed bool) {
 				operator.OnRead(p)
 			} else if operator.Inputs != nil {
 				// for connection
-				var bs = operator.Inputs(p.barriers[i].bs) //len(bs) = 1
-				if len(bs) > 0 {
+				if p.et {
+					// Edge-triggered: EPOLLIN only fires once per readable
+					// transition, so we must drain the fd with a multi-segment
+					// readv loop until EAGAIN, instead of the single len(bs)=1
+					// read the level-triggered path below does. Looping here
+					// (rather than relying on a second wakeup) also guarantees
+					// that, for a peer which closes right after sending its
+					// last bytes, every byte is delivered to Inputs/InputAck
+					// before the EPOLLRDHUP check further down runs appendHup.
+					for {
+						var bs = operator.Inputs(p.barriers[i].bs)
+						if len(bs) == 0 {
+							break
+						}
+						var n, err = readv(operator.FD, bs, p.barriers[i].ivs)
+						operator.InputAck(n)
+						if err != nil {
+							if err == syscall.EINTR {
+								// Edge-triggered mode only gets one EPOLLIN wakeup
+								// per readable transition, so breaking out here
+								// like EAGAIN would leave already-signaled data
+								// unread until some unrelated future event
+								// happened to re-trigger EPOLLIN. Retry the
+								// readv instead of ending the drain.
+								continue
+							}
+							if err != syscall.EAGAIN {
+								logger.Printf("NETPOLL: readv(fd=%d) failed: %s", operator.FD, err.Error())
+								p.appendHup(operator)
+								continue events
+							}
+							break
+						}
+						if n == 0 {
+							// Peer performed an orderly shutdown (EOF), not
+							// EAGAIN: readv will keep returning (0, nil)
+							// forever, so without this check the drain loop
+							// spins the CPU instead of ever seeing EAGAIN.
+							p.appendHup(operator)
+							continue events
+						}
+					}
+				} else {
+					var bs = operator.Inputs(p.barriers[i].bs) //len(bs) = 1
+					if len(bs) > 0 {
 
-					//读取conn中的数据，这边的数据会到connection inputbuffer
-					//由于iovec len一直是1 所以这边readv 没办法 批量读取，发挥一次systemcall 获取多次可读数据的功效
-					var n, err = readv(operator.FD, bs, p.barriers[i].ivs)
+						//读取conn中的数据，这边的数据会到connection inputbuffer
+						//由于iovec len一直是1 所以这边readv 没办法 批量读取，发挥一次systemcall 获取多次可读数据的功效
+						var n, err = readv(operator.FD, bs, p.barriers[i].ivs)
 
-					operator.InputAck(n)
+						operator.InputAck(n)
 
-					if err != nil && err != syscall.EAGAIN && err != syscall.EINTR {
-						logger.Printf("NETPOLL: readv(fd=%d) failed: %s", operator.FD, err.Error())
-						p.appendHup(operator)
-						continue
+						if err != nil && err != syscall.EAGAIN && err != syscall.EINTR {
+							logger.Printf("NETPOLL: readv(fd=%d) failed: %s", operator.FD, err.Error())
+							p.appendHup(operator)
+							continue events
+						}
 					}
 				}
 			} else {
@@ -175,6 +268,17 @@ func (p *defaultPoll) handler(events []epollevent) (closed bool) {
 		if evt&syscall.EPOLLERR != 0 {
 			// Under block-zerocopy, the kernel may give an error callback, which is not a real error, just an EAGAIN.
 			// So here we need to check this error, if it is EAGAIN then do nothing, otherwise still mark as hup.
+			if zeroCopyWrite {
+				// MSG_ZEROCOPY completions also surface as EPOLLERR: drain
+				// MSG_ERRQUEUE and release any writes it confirms before
+				// deciding whether this is a real error.
+				if err := p.handleZeroCopyErrQueue(operator); err != nil && err != syscall.EAGAIN {
+					p.appendHup(operator)
+				} else {
+					operator.done()
+				}
+				continue
+			}
 			if _, _, _, _, err := syscall.Recvmsg(operator.FD, nil, nil, syscall.MSG_ERRQUEUE); err != syscall.EAGAIN {
 				p.appendHup(operator)
 			} else {
@@ -192,9 +296,19 @@ func (p *defaultPoll) handler(events []epollevent) (closed bool) {
 				// for connection
 				var bs, supportZeroCopy = operator.Outputs(p.barriers[i].bs)
 				if len(bs) > 0 {
-					// TODO: Let the upper layer pass in whether to use ZeroCopy.
-					var n, err = sendmsg(operator.FD, bs, p.barriers[i].ivs, false && supportZeroCopy)
-					operator.OutputAck(n)
+					useZeroCopy := zeroCopyWrite && supportZeroCopy && sumLen(bs) >= zeroCopyMinWriteSize
+					var n, err = sendmsg(operator.FD, bs, p.barriers[i].ivs, useZeroCopy)
+					if useZeroCopy && err == nil {
+						// The kernel keeps referencing these pages until it
+						// posts the MSG_ERRQUEUE completion, so OutputAck must
+						// wait for releaseZeroCopyRange to confirm that rather
+						// than running here: acking now would let the
+						// connection recycle/overwrite this memory before the
+						// write actually lands on the wire.
+						p.trackZeroCopyWrite(operator, n)
+					} else {
+						operator.OutputAck(n)
+					}
 					if err != nil && err != syscall.EAGAIN {
 						logger.Printf("NETPOLL: sendmsg(fd=%d) failed: %s", operator.FD, err.Error())
 						p.appendHup(operator)
@@ -237,17 +351,36 @@ func (p *defaultPoll) Control(operator *FDOperator, event PollEvent) error {
 	case PollReadable: // server accept a new connection and wait read
 		operator.inuse()
 		op, evt.events = syscall.EPOLL_CTL_ADD, syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLERR
+		// The listener fd (operator.OnRead != nil) has no drain-accept loop,
+		// so it always stays level-triggered: with EPOLLET it would only
+		// see one accept() per batch of simultaneous connects and stall.
+		// Edge-triggered mode only applies to already-accepted connections.
+		if p.et && operator.OnRead == nil {
+			evt.events |= EPOLLET
+		}
 	case PollWritable: // client create a new connection and wait connect finished
 		operator.inuse()
 		op, evt.events = syscall.EPOLL_CTL_ADD, EPOLLET|syscall.EPOLLOUT|syscall.EPOLLRDHUP|syscall.EPOLLERR
+		if zeroCopyWrite {
+			enableZeroCopy(operator.FD)
+		}
 	case PollModReadable: // client wait read/write
 		op, evt.events = syscall.EPOLL_CTL_MOD, syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLERR
+		if p.et {
+			evt.events |= EPOLLET
+		}
 	case PollDetach: // deregister
 		op, evt.events = syscall.EPOLL_CTL_DEL, syscall.EPOLLIN|syscall.EPOLLOUT|syscall.EPOLLRDHUP|syscall.EPOLLERR
 	case PollR2RW: // connection wait read/write
 		op, evt.events = syscall.EPOLL_CTL_MOD, syscall.EPOLLIN|syscall.EPOLLOUT|syscall.EPOLLRDHUP|syscall.EPOLLERR
+		if zeroCopyWrite {
+			enableZeroCopy(operator.FD)
+		}
 	case PollRW2R: // connection wait read
 		op, evt.events = syscall.EPOLL_CTL_MOD, syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLERR
+		if p.et {
+			evt.events |= EPOLLET
+		}
 	}
 	return EpollCtl(p.fd, op, operator.FD, &evt)
 }
@@ -267,9 +400,84 @@ func (p *defaultPoll) appendHup(operator *FDOperator) {
 	if err := operator.Control(PollDetach); err != nil {
 		logger.Printf("NETPOLL: poller detach operator failed: %v", err)
 	}
+	p.forgetZeroCopyWrites(operator)
 	operator.done()
 }
 
+// deadlineExpired fires when a read or write deadline elapses. Unlike
+// appendHup, it does not detach the operator from epoll or mark it done, and
+// it does not queue operator.OnHup: OnHup is the real hup/close callback
+// every other path in this file uses to tear the connection down, and
+// firing it here would close the connection the first time any
+// SetReadDeadline/SetWriteDeadline elapsed, the opposite of net.Conn
+// semantics. A timeout only needs to end whichever call was blocked
+// waiting, so it queues operator.OnDeadline instead — set by the connection
+// alongside OnHup, but wired to only unpark the blocked Reader()/Writer()
+// with a deadline-exceeded error, leaving the fd registered for a later
+// SetDeadline/Read/Write to keep using.
+func (p *defaultPoll) deadlineExpired(operator *FDOperator) {
+	p.hups = append(p.hups, operator.OnDeadline)
+}
+
+// deadlineKind distinguishes the read and write deadlines of the same
+// operator, since SetDeadline schedules both against one FDOperator.
+type deadlineKind uint8
+
+const (
+	deadlineRead deadlineKind = iota
+	deadlineWrite
+)
+
+type deadlineKey struct {
+	operator *FDOperator
+	kind     deadlineKind
+}
+
+// setDeadline (re)schedules operator's read or write deadline. A zero Time
+// cancels any pending deadline of that kind without scheduling a new one,
+// matching net.Conn.SetReadDeadline/SetWriteDeadline semantics.
+func (p *defaultPoll) setDeadline(operator *FDOperator, kind deadlineKind, deadline time.Time) {
+	key := deadlineKey{operator, kind}
+	if v, ok := p.deadlines.LoadAndDelete(key); ok {
+		p.tw.cancel(v.(*twEntry))
+	}
+	if deadline.IsZero() {
+		return
+	}
+
+	deadlineMs := deadline.UnixNano() / int64(time.Millisecond)
+	now := nowMs()
+	// Read before add(): once the new entry is in the wheel it is itself a
+	// candidate for the nearest expiry, and the comparison below could never
+	// hold for a deadline still in the future.
+	prevNext := msecOrMax(p.tw.nextExpirationMs(now))
+	entry := p.tw.add(deadlineMs, func() {
+		p.deadlines.Delete(key)
+		// Only unpark whatever is blocked in Reader()/Writer(); the operator
+		// stays attached to epoll so the connection is still usable once the
+		// timed-out call returns its deadline-exceeded error.
+		p.deadlineExpired(operator)
+	})
+	p.deadlines.Store(key, entry)
+
+	// A deadline sooner than whatever EpollWait is currently sleeping for
+	// needs to shorten that sleep, otherwise it would only fire on the next
+	// unrelated wakeup.
+	if deadlineMs-now < prevNext {
+		p.Trigger()
+	}
+}
+
+// msecOrMax treats "no pending deadline" (-1) as effectively infinite so the
+// comparison in setDeadline always schedules a Trigger() the first time a
+// deadline is set.
+func msecOrMax(msec int64) int64 {
+	if msec < 0 {
+		return 1<<63 - 1
+	}
+	return msec
+}
+
 func (p *defaultPoll) detaches() {
 	if len(p.hups) == 0 {
 		return
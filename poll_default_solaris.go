@@ -0,0 +1,260 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build solaris && !race
+// +build solaris,!race
+
+package netpoll
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// solaris has no epoll/kqueue; event ports (port_create/port_associate/
+// port_getn) are the portable mechanism, same role here as EVFILT_USER plays
+// for kqueue and the eventfd does for epoll. Unlike both of those, a port
+// association is one-shot: it must be re-armed with PortAssociate after
+// every event it fires, which is why Control() re-associates even on the
+// "no-op" PollModReadable/PollRW2R paths below.
+const triggerEvent = ^uintptr(0) >> 1
+
+func openPoll() Poll {
+	lockOptions()
+	return openDefaultPoll()
+}
+
+func openDefaultPoll() *defaultPoll {
+	var poll = defaultPoll{}
+	fd, err := unix.PortCreate()
+	if err != nil {
+		panic(err)
+	}
+	poll.fd = fd
+
+	poll.Reset = poll.reset
+	poll.Handler = poll.handler
+
+	poll.wop = &FDOperator{FD: int(triggerEvent)}
+	if err := unix.PortAssociate(poll.fd, unix.PORT_SOURCE_USER, int(triggerEvent), 0, nil); err != nil {
+		syscall.Close(poll.fd)
+		panic(err)
+	}
+	poll.opcache = newOperatorCache()
+	return &poll
+}
+
+type defaultPoll struct {
+	fd       int
+	wop      *FDOperator
+	trigger  uint32
+	opcache  *operatorCache
+	size     int
+	events   []unix.PortEvent
+	barriers []barrier
+	hups     []func(p Poll) error
+	// wantEvents tracks each operator's full intended event mask
+	// (POLLIN/POLLOUT/both), since PORT_SOURCE_FD associations are one-shot
+	// and the handler's re-arm after a wakeup must re-associate with what the
+	// connection still wants overall, not just whichever subset of events
+	// fired this time (a POLLIN-only wakeup on a PollR2RW-armed operator must
+	// still re-arm POLLIN|POLLOUT, or write-readiness notifications are lost
+	// for good).
+	wantEvents sync.Map // map[*FDOperator]int
+	Reset      func(size int)
+	Handler    func(events []unix.PortEvent) (closed bool)
+}
+
+func (p *defaultPoll) reset(size int) {
+	p.size = size
+	p.events = make([]unix.PortEvent, size)
+	p.barriers = make([]barrier, size)
+	for i := range p.barriers {
+		p.barriers[i].bs = make([][]byte, barriercap)
+		p.barriers[i].ivs = make([]syscall.Iovec, barriercap)
+	}
+}
+
+// Wait implements Poll.
+func (p *defaultPoll) Wait() (err error) {
+	var msec, n = -1, 0
+	p.Reset(128)
+	for {
+		if n == p.size && p.size < 128*1024 {
+			p.Reset(p.size << 1)
+		}
+		nget := uint32(1)
+		var timeout *unix.Timespec
+		if msec >= 0 {
+			ts := unix.NsecToTimespec(int64(msec) * 1e6)
+			timeout = &ts
+		}
+		err = unix.PortGetn(p.fd, p.events, uint32(len(p.events)), &nget, timeout)
+		n = int(nget)
+		if err != nil && err != unix.EINTR && err != unix.ETIME {
+			return err
+		}
+		if n <= 0 {
+			msec = -1
+			runtime.Gosched()
+			continue
+		}
+		msec = 0
+		if p.Handler(p.events[:n]) {
+			return nil
+		}
+		p.opcache.free()
+	}
+}
+
+func (p *defaultPoll) handler(events []unix.PortEvent) (closed bool) {
+	for i := range events {
+		ev := &events[i]
+		if uintptr(ev.Object) == triggerEvent {
+			atomic.StoreUint32(&p.trigger, 0)
+			unix.PortAssociate(p.fd, unix.PORT_SOURCE_USER, int(triggerEvent), 0, nil)
+			continue
+		}
+
+		operator := (*FDOperator)(unsafe.Pointer(ev.User))
+		if operator == nil || !operator.do() {
+			continue
+		}
+
+		if ev.Events&unix.POLLIN != 0 {
+			if operator.OnRead != nil {
+				operator.OnRead(p)
+			} else if operator.Inputs != nil {
+				var bs = operator.Inputs(p.barriers[i].bs)
+				if len(bs) > 0 {
+					var n, err = readv(operator.FD, bs, p.barriers[i].ivs)
+					operator.InputAck(n)
+					if err != nil && err != syscall.EAGAIN && err != syscall.EINTR {
+						logger.Printf("NETPOLL: readv(fd=%d) failed: %s", operator.FD, err.Error())
+						p.appendHup(operator)
+						continue
+					}
+				}
+			}
+		}
+		if ev.Events&(unix.POLLHUP|unix.POLLERR) != 0 {
+			p.appendHup(operator)
+			continue
+		}
+		if ev.Events&unix.POLLOUT != 0 {
+			if operator.OnWrite != nil {
+				operator.OnWrite(p)
+			} else if operator.Outputs != nil {
+				var bs, supportZeroCopy = operator.Outputs(p.barriers[i].bs)
+				if len(bs) > 0 {
+					var n, err = sendmsg(operator.FD, bs, p.barriers[i].ivs, false && supportZeroCopy)
+					operator.OutputAck(n)
+					if err != nil && err != syscall.EAGAIN {
+						logger.Printf("NETPOLL: sendmsg(fd=%d) failed: %s", operator.FD, err.Error())
+						p.appendHup(operator)
+						continue
+					}
+				}
+			}
+		}
+		// one-shot: re-arm for the next wakeup unless we just detached it
+		// above, with the operator's full intended mask rather than ev.Events
+		// (see wantEvents doc comment).
+		wanted := int(ev.Events)
+		if v, ok := p.wantEvents.Load(operator); ok {
+			wanted = v.(int)
+		}
+		unix.PortAssociate(p.fd, unix.PORT_SOURCE_FD, operator.FD, wanted, unsafe.Pointer(operator))
+		operator.done()
+	}
+	p.detaches()
+	return false
+}
+
+func (p *defaultPoll) Close() error {
+	return syscall.Close(p.fd)
+}
+
+// Trigger implements Poll by posting a PORT_SOURCE_USER event, the event
+// ports analogue of kqueue's EVFILT_USER / epoll's eventfd write.
+func (p *defaultPoll) Trigger() error {
+	if atomic.AddUint32(&p.trigger, 1) > 1 {
+		return nil
+	}
+	return unix.PortSend(p.fd, 0, nil)
+}
+
+// Control implements Poll, mapping the six PollEvent cases below onto
+// port_associate/port_dissociate with POLLIN/POLLOUT.
+func (p *defaultPoll) Control(operator *FDOperator, event PollEvent) error {
+	udata := unsafe.Pointer(operator)
+	switch event {
+	case PollReadable:
+		operator.inuse()
+		p.wantEvents.Store(operator, unix.POLLIN)
+		return unix.PortAssociate(p.fd, unix.PORT_SOURCE_FD, operator.FD, unix.POLLIN, udata)
+	case PollWritable:
+		operator.inuse()
+		p.wantEvents.Store(operator, unix.POLLOUT)
+		return unix.PortAssociate(p.fd, unix.PORT_SOURCE_FD, operator.FD, unix.POLLOUT, udata)
+	case PollModReadable, PollRW2R:
+		p.wantEvents.Store(operator, unix.POLLIN)
+		return unix.PortAssociate(p.fd, unix.PORT_SOURCE_FD, operator.FD, unix.POLLIN, udata)
+	case PollDetach:
+		p.wantEvents.Delete(operator)
+		return unix.PortDissociate(p.fd, unix.PORT_SOURCE_FD, operator.FD)
+	case PollR2RW:
+		p.wantEvents.Store(operator, unix.POLLIN|unix.POLLOUT)
+		return unix.PortAssociate(p.fd, unix.PORT_SOURCE_FD, operator.FD, unix.POLLIN|unix.POLLOUT, udata)
+	}
+	return nil
+}
+
+func (p *defaultPoll) Alloc() (operator *FDOperator) {
+	op := p.opcache.alloc()
+	op.poll = p
+	return op
+}
+
+func (p *defaultPoll) Free(operator *FDOperator) {
+	p.opcache.freeable(operator)
+}
+
+func (p *defaultPoll) appendHup(operator *FDOperator) {
+	p.hups = append(p.hups, operator.OnHup)
+	if err := operator.Control(PollDetach); err != nil {
+		logger.Printf("NETPOLL: poller detach operator failed: %v", err)
+	}
+	operator.done()
+}
+
+func (p *defaultPoll) detaches() {
+	if len(p.hups) == 0 {
+		return
+	}
+	hups := p.hups
+	p.hups = nil
+	go func(onhups []func(p Poll) error) {
+		for i := range onhups {
+			if onhups[i] != nil {
+				onhups[i](p)
+			}
+		}
+	}(hups)
+}
@@ -0,0 +1,140 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !race
+// +build !race
+
+package netpoll
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// PollManager owns a fixed-size pool of pollers and hands them out to the
+// listener's accept loop so new connections are sharded across pollers
+// instead of all piling onto a single epoll fd. Each poller in the pool runs
+// its own Wait() loop, optionally pinned to its own OS thread.
+type PollManager struct {
+	polls []Poll
+	next  uint32
+}
+
+// newPollManager creates n defaultPoll instances, starts a Wait() goroutine
+// for each (LockOSThread'ing it first when lockOSThread is set), and returns
+// a manager that round-robins across them via Pick.
+func newPollManager(n int, lockOSThread bool) *PollManager {
+	if n < 1 {
+		n = 1
+	}
+	pm := &PollManager{polls: make([]Poll, n)}
+	for i := 0; i < n; i++ {
+		poll := openDefaultPoll()
+		pm.polls[i] = poll
+		go func() {
+			if lockOSThread {
+				runtime.LockOSThread()
+				defer runtime.UnlockOSThread()
+			}
+			if err := poll.Wait(); err != nil {
+				logger.Printf("NETPOLL: poll manager shard exited: %v", err)
+			}
+		}()
+	}
+	return pm
+}
+
+// Pick returns the next poller in round-robin order. multiPoll.Alloc uses it
+// for every new operator, accepted and dialed alike, since no fd is known yet
+// at that point to shard on.
+func (m *PollManager) Pick() Poll {
+	n := atomic.AddUint32(&m.next, 1)
+	return m.polls[n%uint32(len(m.polls))]
+}
+
+func (m *PollManager) Close() (err error) {
+	for _, p := range m.polls {
+		if e := p.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// multiPoll fans a single Poll interface out across a PollManager: Alloc
+// round-robins each new FDOperator onto a poller (operator.FD isn't assigned
+// until after Alloc returns, so fd-based sharding isn't available yet), and
+// stamps the operator's own .poll pointer so every later Control/Trigger call
+// for that operator is pinned to the same shard it was allocated to. This is
+// what lets multiPoll be dropped in anywhere a *defaultPoll was expected, e.g.
+// as the listener's poll in the accept loop.
+//
+// Rebalancing: a connection stays on the shard it was allocated to for its
+// whole lifetime. Long-lived idle connections accumulating unevenly across
+// shards is a known limitation of round-robin-at-allocation sharding (same
+// tradeoff gnet makes); moving a live operator to another epoll instance
+// mid-flight would require draining its in-flight Control/Wait state first,
+// which the operator lifecycle here does not support, so it is left as a
+// follow-up rather than done unsafely.
+type multiPoll struct {
+	manager *PollManager
+	closed  chan struct{}
+}
+
+func openMultiPoll(numPollers int, lockOSThread bool) *multiPoll {
+	return &multiPoll{manager: newPollManager(numPollers, lockOSThread), closed: make(chan struct{})}
+}
+
+// Wait implements Poll. Each shard already runs its own Wait() goroutine
+// (started by newPollManager), so the multiPoll handle itself only needs to
+// block until Close() shuts every shard down, the same synchronization point
+// every other Poll.Wait() in this package gives its caller.
+func (p *multiPoll) Wait() error {
+	<-p.closed
+	return nil
+}
+
+func (p *multiPoll) Close() error {
+	err := p.manager.Close()
+	close(p.closed)
+	return err
+}
+
+func (p *multiPoll) Trigger() error {
+	for _, poll := range p.manager.polls {
+		if err := poll.Trigger(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Control always dispatches through operator.poll rather than picking a
+// shard itself: every operator this multiPoll hands out came from its own
+// Alloc, which already stamped .poll, so by the time Control is reachable
+// there is always exactly one poller that owns this operator already.
+func (p *multiPoll) Control(operator *FDOperator, event PollEvent) error {
+	return operator.poll.Control(operator, event)
+}
+
+func (p *multiPoll) Alloc() (operator *FDOperator) {
+	poll := p.manager.Pick()
+	operator = poll.Alloc()
+	operator.poll = poll
+	return operator
+}
+
+func (p *multiPoll) Free(operator *FDOperator) {
+	operator.poll.Free(operator)
+}
@@ -0,0 +1,617 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !race
+// +build !race
+
+package netpoll
+
+import (
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// uringEntries is the submission/completion ring size. The CQ ring is sized
+// 2x the SQ ring by the kernel by default, which is enough headroom for the
+// read+write SQEs netpoll submits per wakeup.
+const uringEntries = 4096
+
+// uringBufSize is the size of each buffer in the registered pool handed to
+// RECV/READV SQEs so the kernel can write directly into netpoll-owned memory
+// instead of bouncing through an anonymous read buffer.
+const uringBufSize = 16 * 1024
+
+// uringBufCount is the number of buffers registered with IORING_REGISTER_BUFFERS.
+const uringBufCount = 256
+
+// openUringPoll feature-detects the running kernel and, if it is capable,
+// sets up a ring and registers a buffer pool. Callers should fall back to
+// openDefaultPoll() if this returns a non-nil error.
+func openUringPoll() (*uringPoll, error) {
+	var params ioUringParams
+	fd, err := ioUringSetup(uringEntries, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	p := new(uringPoll)
+	p.fd = fd
+
+	if err = p.mmapRings(&params); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	if err = p.registerBuffers(); err != nil {
+		p.munmapRings()
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	// eventfd registered as a POLL_ADD SQE takes the place of the epoll
+	// backend's wop: writing to it wakes a blocked io_uring_enter(GETEVENTS).
+	r0, _, e0 := syscall.Syscall(syscall.SYS_EVENTFD2, 0, 0, 0)
+	if e0 != 0 {
+		ioUringRegister(p.fd, ioURingUnregisterBuffers, nil, 0)
+		p.munmapRings()
+		syscall.Close(fd)
+		return nil, e0
+	}
+	p.wop = &FDOperator{FD: int(r0)}
+	p.opcache = newOperatorCache()
+	// wop is an eventfd, not a socket, so it is armed with POLL_ADD directly
+	// rather than through Control (which maps PollReadable to a RECV SQE).
+	// inuse() must still run the 0->1 transition by hand here, the same state
+	// Control() would have set up: operator.do() gates every CQE on a 1->2
+	// CAS, so without it the first uringTagPollAdd completion would fail
+	// do() and we'd never reach the re-arm below, leaving p.trigger stuck
+	// elevated and Trigger() a permanent no-op.
+	p.wop.inuse()
+	p.submitPollAdd(p.wop)
+	return p, nil
+}
+
+// uringPoll is a Poll implementation backed by a single io_uring instance.
+// Instead of arming one epoll interest per fd, it issues a READ_FIXED/SEND
+// SQE per Control() call and re-submits the next one as each completion is
+// handled; completions drain in Wait() and are routed back to the
+// originating FDOperator via the SQE's user_data, which stores the operator
+// pointer (tagged with the SQE kind, see taggedUserData) exactly like
+// events[i].data does for epoll.
+type uringPoll struct {
+	fd  int         // io_uring instance fd
+	wop *FDOperator // eventfd armed with IORING_OP_POLL_ADD, used by Trigger()
+
+	// sqMu serializes every SQ ring mutation (tail/array/sqes) and the
+	// io_uring_enter submit call. Control() is invoked concurrently from
+	// many connection goroutines, unlike epoll_ctl which is independently
+	// atomic per call; the SQ ring's shared tail/index bookkeeping is not.
+	sqMu   sync.Mutex
+	sqRing uringSQRing
+	cqRing uringCQRing
+	sqes   []ioUringSQE
+
+	bufs    [][]byte // registered buffer pool, index == SQE buf_index
+	bufFree []bool
+	bufMu   sync.Mutex
+	// recvWaiters holds operators whose submitRecv couldn't get a buffer
+	// because the pool was momentarily exhausted (steady-state demand is one
+	// buffer per read-armed connection, so >uringBufCount live connections
+	// routinely hits this). freeBuf retries the oldest waiter as soon as a
+	// buffer comes back, instead of leaving that connection's read arm lost
+	// forever.
+	recvWaiters []*FDOperator
+
+	// pendingRecv tracks which pool buffer a given operator's in-flight RECV
+	// targeted, so the completion handler knows where the bytes landed
+	// (cqe.user_data alone only identifies the operator, not the buffer).
+	pendingRecv sync.Map // map[*FDOperator]int
+
+	// ioBarriers holds the single-segment Inputs()/Outputs() destination
+	// each operator reuses across calls, mirroring p.barriers in the epoll
+	// backend without needing one slot per in-flight event.
+	ioBarriers sync.Map // map[*FDOperator]*uringBarrier
+
+	opcache *operatorCache
+	trigger uint32
+	hups    []func(p Poll) error
+}
+
+type uringBarrier struct {
+	bs  [][]byte
+	ivs []syscall.Iovec
+}
+
+func (p *uringPoll) barrierFor(operator *FDOperator) *uringBarrier {
+	v, _ := p.ioBarriers.LoadOrStore(operator, &uringBarrier{bs: make([][]byte, 1), ivs: make([]syscall.Iovec, 1)})
+	return v.(*uringBarrier)
+}
+
+// user_data tagging: Go heap pointers are at least word-aligned, so the low
+// bits are free to carry which SQE kind a completion belongs to. Without
+// this, a RECV and a SEND for the same operator are indistinguishable in the
+// CQE (it only carries back user_data/res/flags, not the opcode).
+const (
+	uringTagMask    = uint64(0x7)
+	uringTagRecv    = uint64(0)
+	uringTagSend    = uint64(1)
+	uringTagPollAdd = uint64(2)
+)
+
+func taggedUserData(operator *FDOperator, tag uint64) uint64 {
+	return uint64(uintptr(unsafe.Pointer(operator))) | tag
+}
+
+func untagUserData(data uint64) (*FDOperator, uint64) {
+	tag := data & uringTagMask
+	ptr := data &^ uringTagMask
+	return (*FDOperator)(unsafe.Pointer(uintptr(ptr))), tag
+}
+
+// copyInto copies src into the (possibly multi-segment) destination bs,
+// returning how many bytes were copied. Used to move a RECV's bytes out of
+// the registered pool buffer and into the connection's own Inputs() memory.
+func copyInto(bs [][]byte, src []byte) int {
+	var copied int
+	for _, b := range bs {
+		if copied >= len(src) {
+			break
+		}
+		copied += copy(b, src[copied:])
+	}
+	return copied
+}
+
+type uringSQRing struct {
+	raw                                  []byte
+	head, tail, ringMask, ringEntries    *uint32
+	flags, dropped                       *uint32
+	array                                []uint32
+}
+
+type uringCQRing struct {
+	raw                               []byte
+	head, tail, ringMask, ringEntries *uint32
+	overflow                          *uint32
+	cqes                              []ioUringCQE
+}
+
+func (p *uringPoll) mmapRings(params *ioUringParams) (err error) {
+	sqSize := params.sqOff.array + params.sqEntries*4
+	sqRaw, err := syscall.Mmap(p.fd, int64(ioURingOffSQRing), int(sqSize),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		return err
+	}
+	p.sqRing.raw = sqRaw
+	base := unsafe.Pointer(&sqRaw[0])
+	p.sqRing.head = (*uint32)(unsafe.Pointer(uintptr(base) + uintptr(params.sqOff.head)))
+	p.sqRing.tail = (*uint32)(unsafe.Pointer(uintptr(base) + uintptr(params.sqOff.tail)))
+	p.sqRing.ringMask = (*uint32)(unsafe.Pointer(uintptr(base) + uintptr(params.sqOff.ringMask)))
+	p.sqRing.ringEntries = (*uint32)(unsafe.Pointer(uintptr(base) + uintptr(params.sqOff.ringEntries)))
+	p.sqRing.flags = (*uint32)(unsafe.Pointer(uintptr(base) + uintptr(params.sqOff.flags)))
+	p.sqRing.dropped = (*uint32)(unsafe.Pointer(uintptr(base) + uintptr(params.sqOff.dropped)))
+	arrPtr := (*uint32)(unsafe.Pointer(uintptr(base) + uintptr(params.sqOff.array)))
+	p.sqRing.array = unsafe.Slice(arrPtr, params.sqEntries)
+
+	sqesRaw, err := syscall.Mmap(p.fd, int64(ioURingOffSQEs), int(params.sqEntries)*int(unsafe.Sizeof(ioUringSQE{})),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		syscall.Munmap(sqRaw)
+		return err
+	}
+	p.sqes = unsafe.Slice((*ioUringSQE)(unsafe.Pointer(&sqesRaw[0])), params.sqEntries)
+
+	cqSize := params.cqOff.cqes + params.cqEntries*uint32(unsafe.Sizeof(ioUringCQE{}))
+	cqRaw, err := syscall.Mmap(p.fd, int64(ioURingOffCQRing), int(cqSize),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		syscall.Munmap(sqRaw)
+		syscall.Munmap(sqesRaw)
+		return err
+	}
+	p.cqRing.raw = cqRaw
+	cbase := unsafe.Pointer(&cqRaw[0])
+	p.cqRing.head = (*uint32)(unsafe.Pointer(uintptr(cbase) + uintptr(params.cqOff.head)))
+	p.cqRing.tail = (*uint32)(unsafe.Pointer(uintptr(cbase) + uintptr(params.cqOff.tail)))
+	p.cqRing.ringMask = (*uint32)(unsafe.Pointer(uintptr(cbase) + uintptr(params.cqOff.ringMask)))
+	p.cqRing.ringEntries = (*uint32)(unsafe.Pointer(uintptr(cbase) + uintptr(params.cqOff.ringEntries)))
+	p.cqRing.overflow = (*uint32)(unsafe.Pointer(uintptr(cbase) + uintptr(params.cqOff.overflow)))
+	cqesPtr := (*ioUringCQE)(unsafe.Pointer(uintptr(cbase) + uintptr(params.cqOff.cqes)))
+	p.cqRing.cqes = unsafe.Slice(cqesPtr, params.cqEntries)
+	return nil
+}
+
+func (p *uringPoll) munmapRings() {
+	syscall.Munmap(p.sqRing.raw)
+	syscall.Munmap(p.cqRing.raw)
+}
+
+// registerBuffers registers p.bufs with IORING_REGISTER_BUFFERS so submitRecv
+// can issue IORING_OP_READ_FIXED against them: the kernel maps these pages
+// once up front instead of pinning/unpinning them on every single read.
+func (p *uringPoll) registerBuffers() error {
+	p.bufs = make([][]byte, uringBufCount)
+	p.bufFree = make([]bool, uringBufCount)
+	iovecs := make([]syscall.Iovec, uringBufCount)
+	for i := range p.bufs {
+		p.bufs[i] = make([]byte, uringBufSize)
+		p.bufFree[i] = true
+		iovecs[i].Base = &p.bufs[i][0]
+		iovecs[i].SetLen(uringBufSize)
+	}
+	return ioUringRegister(p.fd, ioURingRegisterBuffers, unsafe.Pointer(&iovecs[0]), uringBufCount)
+}
+
+// Wait implements Poll: it blocks in io_uring_enter waiting for at least one
+// completion, then drains every CQE currently posted before looping again.
+func (p *uringPoll) Wait() (err error) {
+	for {
+		_, err = ioUringEnter(p.fd, 0, 1, ioURingEnterGetEvents)
+		if err != nil && err != syscall.EINTR {
+			return err
+		}
+		if p.handler() {
+			return nil
+		}
+		p.opcache.free()
+	}
+}
+
+func (p *uringPoll) handler() (closed bool) {
+	head := atomic.LoadUint32(p.cqRing.head)
+	tail := atomic.LoadUint32(p.cqRing.tail)
+	mask := *p.cqRing.ringMask
+
+	for ; head != tail; head++ {
+		cqe := &p.cqRing.cqes[head&mask]
+		operator, tag := untagUserData(cqe.userData)
+		if operator == nil || !operator.do() {
+			continue
+		}
+
+		if operator == p.wop && tag == uringTagPollAdd {
+			atomic.StoreUint32(&p.trigger, 0)
+			if cqe.res >= 0 {
+				// re-arm the eventfd poll so future Trigger() calls wake us again
+				p.submitPollAdd(p.wop)
+			}
+			operator.done()
+			continue
+		}
+
+		switch tag {
+		case uringTagRecv:
+			p.handleRecvCompletion(operator, cqe)
+		case uringTagSend:
+			p.handleSendCompletion(operator, cqe)
+		}
+		operator.done()
+	}
+	atomic.StoreUint32(p.cqRing.head, head)
+
+	p.detaches()
+	return false
+}
+
+// handleRecvCompletion moves a finished RECV's bytes out of the registered
+// pool buffer it targeted and into the connection's own Inputs() memory,
+// then re-arms another RECV so reads keep flowing (this backend polls
+// level-triggered style: one RECV always in flight while a connection is
+// readable).
+func (p *uringPoll) handleRecvCompletion(operator *FDOperator, cqe *ioUringCQE) {
+	bufIdx := -1
+	if v, ok := p.pendingRecv.LoadAndDelete(operator); ok {
+		bufIdx = v.(int)
+	}
+	defer func() {
+		if bufIdx >= 0 {
+			p.freeBuf(bufIdx)
+		}
+	}()
+
+	switch {
+	case cqe.res < 0:
+		errno := syscall.Errno(-cqe.res)
+		if errno == syscall.ECANCELED {
+			// Posted by submitCancel's IORING_OP_ASYNC_CANCEL during a normal
+			// PollDetach: the operator is already detached there, so logging
+			// this as a failure and hupping it would be spurious on every
+			// ordinary connection close.
+			return
+		}
+		if errno != syscall.EAGAIN && errno != syscall.EINTR {
+			logger.Printf("NETPOLL: uring recv(fd=%d) failed: %s", operator.FD, errno.Error())
+			p.appendHup(operator)
+			return
+		}
+	case cqe.res == 0:
+		// peer performed an orderly shutdown; nothing left to read.
+		p.appendHup(operator)
+		return
+	case bufIdx >= 0:
+		// A single Inputs() call only offers however much space the
+		// connection's current LinkBuffer block has left, which is
+		// typically well under uringBufSize (16KB); looping here (instead
+		// of a single copyInto+InputAck) is what keeps a large RECV from
+		// silently dropping whatever didn't fit in that first segment.
+		src := p.bufs[bufIdx][:cqe.res]
+		bar := p.barrierFor(operator)
+		for len(src) > 0 {
+			bs := operator.Inputs(bar.bs)
+			if len(bs) == 0 {
+				break
+			}
+			copied := copyInto(bs, src)
+			operator.InputAck(copied)
+			if copied == 0 {
+				break
+			}
+			src = src[copied:]
+		}
+	}
+
+	if operator.Inputs != nil {
+		if err := p.submitRecv(operator); err != nil && err != syscall.EAGAIN {
+			logger.Printf("NETPOLL: uring re-submit recv(fd=%d) failed: %s", operator.FD, err.Error())
+		}
+	}
+}
+
+func (p *uringPoll) handleSendCompletion(operator *FDOperator, cqe *ioUringCQE) {
+	if cqe.res < 0 {
+		errno := syscall.Errno(-cqe.res)
+		if errno != syscall.EAGAIN && errno != syscall.EINTR {
+			logger.Printf("NETPOLL: uring send(fd=%d) failed: %s", operator.FD, errno.Error())
+			p.appendHup(operator)
+			return
+		}
+		return
+	}
+	operator.OutputAck(int(cqe.res))
+	// Mirror handleRecvCompletion: a write needing more than one SQE (a
+	// multi-segment Outputs(), or a partial send) only gets resubmitted
+	// here, nowhere else, so stopping after the first completion would
+	// silently strand it until some unrelated Control(PollWritable) call
+	// happened to re-arm it. submitSend is itself a no-op once Outputs()
+	// has nothing left to offer.
+	if err := p.submitSend(operator); err != nil && err != syscall.EAGAIN {
+		logger.Printf("NETPOLL: uring re-submit send(fd=%d) failed: %s", operator.FD, err.Error())
+	}
+}
+
+// submitPollAdd pushes a POLL_ADD SQE for the trigger eventfd so the ring
+// itself wakes Wait() the next time someone calls Trigger().
+func (p *uringPoll) submitPollAdd(operator *FDOperator) {
+	p.sqMu.Lock()
+	defer p.sqMu.Unlock()
+
+	tail := *p.sqRing.tail
+	idx := tail & *p.sqRing.ringMask
+	sqe := &p.sqes[idx]
+	*sqe = ioUringSQE{
+		opcode:   ioURingOpPollAdd,
+		fd:       int32(operator.FD),
+		userData: taggedUserData(operator, uringTagPollAdd),
+	}
+	sqe.unionFlags = syscall.POLLIN
+	p.sqRing.array[idx] = idx
+	atomic.StoreUint32(p.sqRing.tail, tail+1)
+	ioUringEnter(p.fd, 1, 0, 0)
+}
+
+// Close implements Poll.
+func (p *uringPoll) Close() error {
+	p.munmapRings()
+	syscall.Close(p.wop.FD)
+	return syscall.Close(p.fd)
+}
+
+// Trigger implements Poll: writing to the eventfd completes the POLL_ADD SQE
+// submitted for it, which is how Wait()'s io_uring_enter unblocks.
+func (p *uringPoll) Trigger() error {
+	if atomic.AddUint32(&p.trigger, 1) > 1 {
+		return nil
+	}
+	_, err := syscall.Write(p.wop.FD, []byte{0, 0, 0, 0, 0, 0, 0, 1})
+	return err
+}
+
+// Control implements Poll: PollReadable/PollWritable arm a RECV/SEND SQE
+// ahead of time (level-triggered style, re-submitted after every completion),
+// PollDetach issues IORING_OP_ASYNC_CANCEL against the operator's in-flight
+// RECV (its recv-tagged user_data); an in-flight SEND is not cancelled.
+func (p *uringPoll) Control(operator *FDOperator, event PollEvent) error {
+	switch event {
+	case PollReadable, PollModReadable, PollRW2R:
+		operator.inuse()
+		return p.submitRecv(operator)
+	case PollWritable, PollR2RW:
+		operator.inuse()
+		return p.submitSend(operator)
+	case PollDetach:
+		return p.submitCancel(operator)
+	}
+	return nil
+}
+
+func (p *uringPoll) submitRecv(operator *FDOperator) error {
+	bufIdx, ok := p.allocBuf()
+	if !ok {
+		// Queue instead of dropping the read arm: freeBuf will retry this
+		// operator as soon as some other connection's RECV completes and
+		// frees a buffer, so the connection eventually gets armed rather
+		// than stalling silently until something unrelated calls Control
+		// again.
+		p.bufMu.Lock()
+		p.recvWaiters = append(p.recvWaiters, operator)
+		p.bufMu.Unlock()
+		return nil
+	}
+
+	p.sqMu.Lock()
+	tail := *p.sqRing.tail
+	idx := tail & *p.sqRing.ringMask
+	sqe := &p.sqes[idx]
+	// IORING_OP_READ_FIXED (not plain RECV) so this actually reads through
+	// the registered buffer table: buf_index tells the kernel which pre-
+	// mapped p.bufs[bufIdx] entry addr/len point into, avoiding a pin/unpin
+	// of those pages on every call the way an unregistered RECV would need.
+	*sqe = ioUringSQE{
+		opcode:   ioURingOpReadFixed,
+		fd:       int32(operator.FD),
+		addr:     uint64(uintptr(unsafe.Pointer(&p.bufs[bufIdx][0]))),
+		len:      uringBufSize,
+		userData: taggedUserData(operator, uringTagRecv),
+		bufIG:    uint16(bufIdx),
+	}
+	p.sqRing.array[idx] = idx
+	atomic.StoreUint32(p.sqRing.tail, tail+1)
+	_, err := ioUringEnter(p.fd, 1, 0, 0)
+	p.sqMu.Unlock()
+
+	if err != nil {
+		p.freeBuf(bufIdx)
+		return err
+	}
+	p.pendingRecv.Store(operator, bufIdx)
+	return nil
+}
+
+func (p *uringPoll) submitSend(operator *FDOperator) error {
+	bar := p.barrierFor(operator)
+	bs, _ := operator.Outputs(bar.bs)
+	if len(bs) == 0 || len(bs[0]) == 0 {
+		return nil
+	}
+
+	p.sqMu.Lock()
+	defer p.sqMu.Unlock()
+
+	tail := *p.sqRing.tail
+	idx := tail & *p.sqRing.ringMask
+	sqe := &p.sqes[idx]
+	*sqe = ioUringSQE{
+		opcode:   ioURingOpSend,
+		fd:       int32(operator.FD),
+		addr:     uint64(uintptr(unsafe.Pointer(&bs[0][0]))),
+		len:      uint32(len(bs[0])),
+		userData: taggedUserData(operator, uringTagSend),
+	}
+	p.sqRing.array[idx] = idx
+	atomic.StoreUint32(p.sqRing.tail, tail+1)
+	_, err := ioUringEnter(p.fd, 1, 0, 0)
+	return err
+}
+
+func (p *uringPoll) submitCancel(operator *FDOperator) error {
+	p.sqMu.Lock()
+	tail := *p.sqRing.tail
+	idx := tail & *p.sqRing.ringMask
+	sqe := &p.sqes[idx]
+	*sqe = ioUringSQE{
+		opcode: ioURingOpAsyncCancel,
+		fd:     int32(operator.FD),
+		addr:   taggedUserData(operator, uringTagRecv),
+	}
+	p.sqRing.array[idx] = idx
+	atomic.StoreUint32(p.sqRing.tail, tail+1)
+	_, err := ioUringEnter(p.fd, 1, 0, 0)
+	p.sqMu.Unlock()
+
+	if v, ok := p.pendingRecv.LoadAndDelete(operator); ok {
+		p.freeBuf(v.(int))
+	}
+	p.bufMu.Lock()
+	for i, w := range p.recvWaiters {
+		if w == operator {
+			p.recvWaiters = append(p.recvWaiters[:i], p.recvWaiters[i+1:]...)
+			break
+		}
+	}
+	p.bufMu.Unlock()
+	operator.done()
+	return err
+}
+
+func (p *uringPoll) allocBuf() (idx int, ok bool) {
+	p.bufMu.Lock()
+	defer p.bufMu.Unlock()
+	for i, free := range p.bufFree {
+		if free {
+			p.bufFree[i] = false
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (p *uringPoll) freeBuf(idx int) {
+	p.bufMu.Lock()
+	p.bufFree[idx] = true
+	var waiter *FDOperator
+	if len(p.recvWaiters) > 0 {
+		waiter = p.recvWaiters[0]
+		p.recvWaiters = p.recvWaiters[1:]
+	}
+	p.bufMu.Unlock()
+
+	if waiter != nil {
+		if err := p.submitRecv(waiter); err != nil && err != syscall.EAGAIN {
+			logger.Printf("NETPOLL: uring re-submit recv(fd=%d) failed: %s", waiter.FD, err.Error())
+		}
+	}
+}
+
+func (p *uringPoll) Alloc() (operator *FDOperator) {
+	op := p.opcache.alloc()
+	op.poll = p
+	return op
+}
+
+func (p *uringPoll) Free(operator *FDOperator) {
+	p.opcache.freeable(operator)
+}
+
+func (p *uringPoll) appendHup(operator *FDOperator) {
+	p.hups = append(p.hups, operator.OnHup)
+	if err := operator.Control(PollDetach); err != nil {
+		logger.Printf("NETPOLL: uring poller detach operator failed: %v", err)
+	}
+	operator.done()
+}
+
+func (p *uringPoll) detaches() {
+	if len(p.hups) == 0 {
+		return
+	}
+	hups := p.hups
+	p.hups = nil
+	go func(onhups []func(p Poll) error) {
+		for i := range onhups {
+			if onhups[i] != nil {
+				onhups[i](p)
+			}
+		}
+	}(hups)
+}
+
+// mmap offsets for the magic SQ/CQ/SQE regions, per io_uring_setup(2).
+const (
+	ioURingOffSQRing = 0
+	ioURingOffCQRing = 0x8000000
+	ioURingOffSQEs   = 0x10000000
+)
@@ -0,0 +1,184 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !race
+// +build !race
+
+package netpoll
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	soZeroCopy         = 60 // SOL_SOCKET, SO_ZEROCOPY (linux >= 4.14)
+	soEEOriginZeroCopy = 5  // sock_extended_err.ee_origin for MSG_ZEROCOPY completions
+
+	// zeroCopyMinWriteSize is the smallest write that actually benefits from
+	// MSG_ZEROCOPY: below this the page-pinning + errqueue bookkeeping costs
+	// more than the copy it avoids, so smaller writes always fall back to a
+	// regular copying sendmsg.
+	zeroCopyMinWriteSize = 10 * 1024
+)
+
+// sockExtendedErr mirrors struct sock_extended_err from linux/errqueue.h.
+// For SO_EE_ORIGIN_ZEROCOPY completions, eeInfo/eeData hold the inclusive
+// [lo, hi] range of MSG_ZEROCOPY call counters (per-socket, starting at 0)
+// that have become safe to release.
+type sockExtendedErr struct {
+	eeErrno uint32
+	eeOrigin uint8
+	eeType   uint8
+	eeCode   uint8
+	eePad    uint8
+	eeInfo   uint32
+	eeData   uint32
+}
+
+// errZeroCopySocketError is returned by handleZeroCopyErrQueue when it drains
+// an errqueue entry whose ee_origin is not SO_EE_ORIGIN_ZEROCOPY, i.e. a
+// genuine socket error reported via IP_RECVERR/ICMP rather than a
+// MSG_ZEROCOPY completion. It carries no information beyond "not EAGAIN", so
+// the caller's existing EPOLLERR handling treats it the same as any other
+// real error and hups the connection.
+var errZeroCopySocketError = errors.New("netpoll: zerocopy errqueue reported a non-zerocopy socket error")
+
+// zcWrite is one outstanding MSG_ZEROCOPY sendmsg call: counter is the
+// kernel's per-socket notification number it will be confirmed under, and n
+// is the byte count that was handed to sendmsg, i.e. what operator.OutputAck
+// must still be given once that confirmation arrives.
+type zcWrite struct {
+	counter uint32
+	n       int
+}
+
+// zcQueue tracks the kernel's per-socket MSG_ZEROCOPY notification counter
+// and which writes are still waiting on their completion.
+//
+// This is the safety mechanism MSG_ZEROCOPY requires: the kernel keeps
+// referencing the userspace pages a zerocopy sendmsg was given until it
+// posts the completion on MSG_ERRQUEUE, so operator.OutputAck for a zerocopy
+// write must not run until releaseZeroCopyRange confirms it — acking (and
+// so letting the connection recycle/overwrite that memory) any earlier would
+// let a subsequent write corrupt bytes of this one still in flight.
+type zcQueue struct {
+	mu      sync.Mutex
+	counter uint32
+	pending []zcWrite
+}
+
+// enableZeroCopy sets SO_ZEROCOPY on operator's fd. Best-effort: on a kernel
+// too old to support it, sendmsg(MSG_ZEROCOPY) below would fail per-call
+// anyway (falling back through the normal error path), so a setsockopt
+// failure here is not fatal.
+func enableZeroCopy(fd int) {
+	syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, soZeroCopy, 1)
+}
+
+// trackZeroCopyWrite records that a MSG_ZEROCOPY sendmsg call of n bytes was
+// just made, so its eventual errqueue notification has something to match
+// against and, once matched, something to OutputAck.
+func (p *defaultPoll) trackZeroCopyWrite(operator *FDOperator, n int) {
+	v, _ := p.zcPending.LoadOrStore(operator, &zcQueue{})
+	q := v.(*zcQueue)
+	q.mu.Lock()
+	counter := q.counter
+	q.counter++
+	q.pending = append(q.pending, zcWrite{counter: counter, n: n})
+	q.mu.Unlock()
+}
+
+// handleZeroCopyErrQueue drains every MSG_ERRQUEUE notification currently
+// pending for operator's fd and releases the zerocopy writes they confirm.
+func (p *defaultPoll) handleZeroCopyErrQueue(operator *FDOperator) error {
+	oob := make([]byte, 128)
+	var sawSocketError bool
+	for {
+		_, oobn, _, _, err := syscall.Recvmsg(operator.FD, nil, oob, syscall.MSG_ERRQUEUE)
+		if err != nil {
+			if err == syscall.EAGAIN {
+				if sawSocketError {
+					return errZeroCopySocketError
+				}
+				return nil
+			}
+			return err
+		}
+		scms, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return nil
+		}
+		for _, scm := range scms {
+			if len(scm.Data) < int(unsafe.Sizeof(sockExtendedErr{})) {
+				continue
+			}
+			serr := (*sockExtendedErr)(unsafe.Pointer(&scm.Data[0]))
+			if serr.eeOrigin != soEEOriginZeroCopy {
+				// A genuine socket error delivered via IP_RECVERR/ICMP, not a
+				// zerocopy completion: keep draining so the errqueue doesn't
+				// back up, but remember to report it once EAGAIN is reached
+				// instead of silently dropping it like a completion.
+				sawSocketError = true
+				continue
+			}
+			p.releaseZeroCopyRange(operator, serr.eeInfo, serr.eeData)
+		}
+	}
+}
+
+// releaseZeroCopyRange is what actually makes a zerocopy write's memory safe
+// to reuse: every tracked write whose counter falls in the kernel-reported
+// [lo, hi] completed range has its byte count handed to operator.OutputAck
+// here, for the first time, now that the kernel has confirmed it is done
+// reading those pages.
+func (p *defaultPoll) releaseZeroCopyRange(operator *FDOperator, lo, hi uint32) {
+	v, ok := p.zcPending.Load(operator)
+	if !ok {
+		return
+	}
+	q := v.(*zcQueue)
+	q.mu.Lock()
+	var acked int
+	remaining := q.pending[:0]
+	for _, w := range q.pending {
+		if w.counter < lo || w.counter > hi {
+			remaining = append(remaining, w)
+		} else {
+			acked += w.n
+		}
+	}
+	q.pending = remaining
+	q.mu.Unlock()
+	if acked > 0 {
+		operator.OutputAck(acked)
+	}
+}
+
+// forgetZeroCopyWrites drops operator's zcQueue entirely. Called on
+// detach/close so a connection that used MSG_ZEROCOPY doesn't leak its
+// tracking state forever in p.zcPending.
+func (p *defaultPoll) forgetZeroCopyWrites(operator *FDOperator) {
+	p.zcPending.Delete(operator)
+}
+
+func sumLen(bs [][]byte) int {
+	var n int
+	for _, b := range bs {
+		n += len(b)
+	}
+	return n
+}
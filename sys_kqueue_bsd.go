@@ -0,0 +1,56 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+package netpoll
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// KqueueCreate wraps kqueue(2), mirroring the EpollCreate helper the epoll
+// backend uses.
+func KqueueCreate() (fd int, err error) {
+	return syscall.Kqueue()
+}
+
+// KeventCtl applies a batch of filter/fd changes in one kevent(2) call, the
+// kqueue analogue of EpollCtl.
+func KeventCtl(kq int, changes []syscall.Kevent_t) error {
+	_, err := syscall.Kevent(kq, changes, nil, nil)
+	return err
+}
+
+// KeventWait blocks (or polls, if msec == 0) for events, the kqueue analogue
+// of EpollWait. A negative msec blocks indefinitely.
+func KeventWait(kq int, events []syscall.Kevent_t, msec int) (n int, err error) {
+	if msec < 0 {
+		return syscall.Kevent(kq, nil, events, nil)
+	}
+	ts := syscall.NsecToTimespec(int64(msec) * 1e6)
+	return syscall.Kevent(kq, nil, events, &ts)
+}
+
+// operatorFromUdata recovers the *FDOperator stashed in a kevent's udata
+// field, exactly like the epoll backend stashes it in epollevent.data.
+func operatorFromUdata(udata *byte) *FDOperator {
+	return (*FDOperator)(unsafe.Pointer(udata))
+}
+
+func operatorToUdata(operator *FDOperator) *byte {
+	return (*byte)(unsafe.Pointer(operator))
+}
@@ -0,0 +1,167 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package netpoll
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// minimal set of io_uring syscalls/uapi structs required by uringPoll.
+// Mirrors linux/io_uring.h; struct layouts match the kernel's, including
+// fields netpoll never touches.
+
+const (
+	sysIOUringSetup    = 425
+	sysIOUringEnter    = 426
+	sysIOUringRegister = 427
+)
+
+const (
+	ioURingOpReadv          = 1
+	ioURingOpWritev         = 2
+	ioURingOpReadFixed      = 4
+	ioURingOpPollAdd        = 6
+	ioURingOpPollRemove     = 7
+	ioURingOpProvideBuffers = 31
+	ioURingOpAsyncCancel    = 14
+	ioURingOpSend           = 26
+	ioURingOpRecv           = 27
+)
+
+const (
+	ioURingFeatFastPoll = 1 << 5
+	ioURingFeatExtArg   = 1 << 8 // first reported by Linux 5.11
+)
+
+const (
+	ioURingRegisterBuffers   = 0
+	ioURingUnregisterBuffers = 1
+)
+
+const (
+	ioURingEnterGetEvents = 1 << 0
+)
+
+const (
+	ioURingSQNeedWakeup = 1 << 0
+)
+
+type ioSqringOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	flags       uint32
+	dropped     uint32
+	array       uint32
+	resv1       uint32
+	resv2       uint64
+}
+
+type ioCqringOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	overflow    uint32
+	cqes        uint32
+	flags       uint32
+	resv1       uint32
+	resv2       uint64
+}
+
+type ioUringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        ioSqringOffsets
+	cqOff        ioCqringOffsets
+}
+
+// ioUringSQE is the 64-byte submission queue entry.
+type ioUringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	unionFlags  uint32
+	userData    uint64
+	bufIG       uint16
+	personality uint16
+	spliceFDIn  int32
+	pad2        [2]uint64
+}
+
+// ioUringCQE is the 16-byte completion queue entry.
+type ioUringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+func ioUringSetup(entries uint32, params *ioUringParams) (fd int, err error) {
+	r1, _, e1 := syscall.Syscall(sysIOUringSetup, uintptr(entries), uintptr(unsafe.Pointer(params)), 0)
+	if e1 != 0 {
+		return 0, e1
+	}
+	return int(r1), nil
+}
+
+func ioUringEnter(fd int, toSubmit, minComplete uint32, flags uint32) (n int, err error) {
+	r1, _, e1 := syscall.Syscall6(sysIOUringEnter, uintptr(fd), uintptr(toSubmit), uintptr(minComplete), uintptr(flags), 0, 0)
+	if e1 != 0 {
+		return 0, e1
+	}
+	return int(r1), nil
+}
+
+func ioUringRegister(fd int, op uint32, arg unsafe.Pointer, nrArgs uint32) (err error) {
+	_, _, e1 := syscall.Syscall6(sysIOUringRegister, uintptr(fd), uintptr(op), uintptr(arg), uintptr(nrArgs), 0, 0)
+	if e1 != 0 {
+		return e1
+	}
+	return nil
+}
+
+// kernelSupportsIOUring feature-detects io_uring with the operations netpoll
+// needs (fast poll + provide-buffers), returning false on anything older than
+// Linux 5.11 or when io_uring is disabled (seccomp, container policy, ...).
+func kernelSupportsIOUring() bool {
+	var params ioUringParams
+	fd, err := ioUringSetup(8, &params)
+	if err != nil {
+		return false
+	}
+	defer syscall.Close(fd)
+	if params.features&ioURingFeatFastPoll == 0 {
+		return false
+	}
+	// IORING_FEAT_EXT_ARG is first reported by 5.11, so its presence stands in
+	// for the kernel version check; IORING_OP_PROVIDE_BUFFERS (5.7) and the
+	// RECV/SEND zero-length-buffer-group fixes (5.11) are implied by it.
+	return params.features&ioURingFeatExtArg != 0
+}
@@ -0,0 +1,264 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// timingWheel tracks per-connection read/write deadlines for one defaultPoll
+// in a hierarchical (4-level) bucket structure, Kafka/Netty style, which
+// add()/cancel() use as a doubly-linked list to splice an entry in or out in
+// O(1) without touching any other entry. add()/cancel() also push/remove the
+// entry on a min-heap, which is O(log n), so neither is O(1) overall. Firing is driven entirely by the small min-heap of the
+// same entries (tw.nearest) that also backs nextExpirationMs — see advance's
+// doc comment for why buckets are not walked on the firing path. Each
+// defaultPoll owns exactly one timingWheel, so deadline bookkeeping for every
+// connection on that poller happens without extra goroutines.
+type timingWheel struct {
+	mu     sync.Mutex
+	levels [twNumLevels]*twLevel
+	// currentMs is the wheel's notion of "now", advanced explicitly from
+	// Wait() rather than a background ticker so expiry stays on the same
+	// goroutine that already owns epoll_wait.
+	currentMs int64
+	nearest   twHeap
+}
+
+const (
+	twTickMs    = 1   // level 0 resolution
+	twWheelSize = 256 // buckets per level
+	twNumLevels = 4
+)
+
+type twEntry struct {
+	deadlineMs int64
+	fn         func()
+	prev, next *twEntry
+	bucket     *twBucket
+	// heapIdx is this entry's index in timingWheel.nearest, or -1 once it
+	// has fired or been cancelled.
+	heapIdx int
+}
+
+// twHeap is a container/heap min-heap of *twEntry ordered by deadlineMs, so
+// the soonest-expiring entry is always at twHeap[0].
+type twHeap []*twEntry
+
+func (h twHeap) Len() int           { return len(h) }
+func (h twHeap) Less(i, j int) bool { return h[i].deadlineMs < h[j].deadlineMs }
+func (h twHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIdx, h[j].heapIdx = i, j
+}
+
+func (h *twHeap) Push(x interface{}) {
+	e := x.(*twEntry)
+	e.heapIdx = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *twHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.heapIdx = -1
+	*h = old[:n-1]
+	return e
+}
+
+type twBucket struct {
+	mu         sync.Mutex
+	head, tail *twEntry
+	expiresMs  int64
+}
+
+func (b *twBucket) add(e *twEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e.bucket = b
+	e.prev, e.next = b.tail, nil
+	if b.tail != nil {
+		b.tail.next = e
+	} else {
+		b.head = e
+	}
+	b.tail = e
+}
+
+func (b *twBucket) remove(e *twEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e.prev != nil {
+		e.prev.next = e.next
+	} else if b.head == e {
+		b.head = e.next
+	}
+	if e.next != nil {
+		e.next.prev = e.prev
+	} else if b.tail == e {
+		b.tail = e.prev
+	}
+	e.prev, e.next, e.bucket = nil, nil, nil
+}
+
+// drain detaches and returns every entry currently in the bucket.
+func (b *twBucket) drain() []*twEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var entries []*twEntry
+	for e := b.head; e != nil; {
+		next := e.next
+		e.prev, e.next, e.bucket = nil, nil, nil
+		entries = append(entries, e)
+		e = next
+	}
+	b.head, b.tail = nil, nil
+	return entries
+}
+
+type twLevel struct {
+	tickMs  int64
+	buckets []*twBucket
+}
+
+func newTimingWheel(nowMs int64) *timingWheel {
+	tw := &timingWheel{currentMs: nowMs}
+	tick := int64(twTickMs)
+	for l := 0; l < twNumLevels; l++ {
+		lvl := &twLevel{tickMs: tick, buckets: make([]*twBucket, twWheelSize)}
+		for i := range lvl.buckets {
+			lvl.buckets[i] = &twBucket{}
+		}
+		tw.levels[l] = lvl
+		tick *= twWheelSize
+	}
+	return tw
+}
+
+// add schedules fn to run once the wheel's clock reaches deadlineMs,
+// returning the entry so callers (e.g. SetReadDeadline replacing an earlier
+// deadline) can cancel it again.
+func (tw *timingWheel) add(deadlineMs int64, fn func()) *twEntry {
+	e := &twEntry{deadlineMs: deadlineMs, fn: fn, heapIdx: -1}
+	tw.schedule(e)
+	tw.mu.Lock()
+	heap.Push(&tw.nearest, e)
+	tw.mu.Unlock()
+	return e
+}
+
+func (tw *timingWheel) schedule(e *twEntry) {
+	tw.mu.Lock()
+	now := tw.currentMs
+	tw.mu.Unlock()
+
+	delay := e.deadlineMs - now
+	if delay < 0 {
+		delay = 0
+	}
+	for l := 0; l < twNumLevels; l++ {
+		lvl := tw.levels[l]
+		span := lvl.tickMs * twWheelSize
+		if delay < span || l == twNumLevels-1 {
+			idx := (e.deadlineMs / lvl.tickMs) % twWheelSize
+			lvl.buckets[idx].add(e)
+			return
+		}
+	}
+}
+
+// unheap drops e from the nearest-expiry heap. Safe to call more than once,
+// and safe to call on an entry that was never pushed (heapIdx stays -1).
+func (tw *timingWheel) unheap(e *twEntry) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if e.heapIdx < 0 || e.heapIdx >= len(tw.nearest) || tw.nearest[e.heapIdx] != e {
+		return
+	}
+	heap.Remove(&tw.nearest, e.heapIdx)
+}
+
+// cancel removes e before it fires. Safe to call after it has already fired.
+func (tw *timingWheel) cancel(e *twEntry) {
+	if e == nil {
+		return
+	}
+	if b := e.bucket; b != nil {
+		b.remove(e)
+	}
+	tw.unheap(e)
+}
+
+// advance moves the wheel's clock to nowMs, firing every entry whose deadline
+// has passed. Call this from Wait() right after EpollWait returns so expiry
+// runs on the poller goroutine, never a timer goroutine of its own.
+//
+// It is driven entirely off tw.nearest (the min-heap also used by
+// nextExpirationMs), popping and firing the soonest entry until the heap is
+// empty or its root is still in the future, rather than single-stepping
+// every elapsed millisecond through the bucket levels: EpollWait commonly
+// blocks for minutes or hours with msec=-1 when nothing is scheduled (see
+// nextExpirationMs), and a tick-by-tick walk from the old clock to nowMs
+// after a stretch like that would spin the poller goroutine once per elapsed
+// ms before it ever got to handling the event that just woke it. Cost here
+// is proportional to the number of entries actually due, not to elapsed
+// time, and unlike a bucket scan it is correct regardless of how coarse a
+// level an entry happens to be sitting in.
+func (tw *timingWheel) advance(nowMs int64) {
+	for {
+		tw.mu.Lock()
+		if len(tw.nearest) == 0 || tw.nearest[0].deadlineMs > nowMs {
+			if nowMs > tw.currentMs {
+				tw.currentMs = nowMs
+			}
+			tw.mu.Unlock()
+			return
+		}
+		e := heap.Pop(&tw.nearest).(*twEntry)
+		tw.mu.Unlock()
+
+		if b := e.bucket; b != nil {
+			b.remove(e)
+		}
+		e.fn()
+	}
+}
+
+// nextExpirationMs returns the number of milliseconds until the nearest
+// scheduled entry, or -1 if the wheel is empty. defaultPoll.Wait() feeds this
+// straight into EpollWait's msec argument so a near deadline shortens the
+// sleep instead of the previous -1/0 alternation. Backed by the nearest-expiry
+// heap rather than a bucket scan, so it stays O(1) regardless of how many
+// deadlines are outstanding.
+func (tw *timingWheel) nextExpirationMs(nowMs int64) int64 {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if len(tw.nearest) == 0 {
+		return -1
+	}
+	d := tw.nearest[0].deadlineMs - nowMs
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+func nowMs() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}